@@ -0,0 +1,129 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func testEntries(n int) []entry {
+	data := make([]entry, n)
+	for i := range data {
+		data[i] = entry{
+			Lower: fmt.Sprintf("keyword%d", i),
+			Match: fmt.Sprintf("KEYWORD%d", i),
+		}
+	}
+	return data
+}
+
+func TestBuildKeywordHashTable(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 10, 37, 120, 500} {
+		data := testEntries(n)
+		tbl := buildKeywordHashTable(data)
+
+		if tbl.Size > maxHashTableSizeFactor*n {
+			t.Fatalf("n=%d: table size %d exceeds %dx bound", n, tbl.Size, maxHashTableSizeFactor)
+		}
+		if len(tbl.Map) != tbl.Size || len(tbl.Strs) != tbl.Size {
+			t.Fatalf("n=%d: Map/Strs length %d/%d does not match Size %d", n, len(tbl.Map), len(tbl.Strs), tbl.Size)
+		}
+
+		seen := map[string]bool{}
+		for _, e := range data {
+			bucket := keywordHash(e.Lower, tbl.MultiplierB) & tbl.BucketMask
+			slot := (keywordHash(e.Lower, tbl.MultiplierF) + tbl.Disp[bucket]) & tbl.Mask
+			if tbl.Map[slot] != e.Match || tbl.Strs[slot] != e.Lower {
+				t.Fatalf("n=%d: keyword %q did not land in its assigned slot %d", n, e.Lower, slot)
+			}
+			if seen[fmt.Sprint(slot)] {
+				t.Fatalf("n=%d: slot %d used by more than one keyword", n, slot)
+			}
+			seen[fmt.Sprint(slot)] = true
+		}
+	}
+}
+
+// acMatch mirrors one call to ScanKeywords' visit callback.
+type acMatch struct {
+	start, end int
+	tok        string
+}
+
+// scanWithAutomaton drives an acAutomaton exactly as the ScanKeywords
+// template does, so tests can exercise buildACAutomaton's output without
+// depending on the generated keywordsAC.go.
+func scanWithAutomaton(auto acAutomaton, input string) []acMatch {
+	var got []acMatch
+	state := int32(0)
+	for i := 0; i < len(input); i++ {
+		b := input[i]
+		if b >= 'A' && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		state = auto.Goto[int(state)*256+int(b)]
+		for o := auto.OutStart[state]; o < auto.OutStart[state+1]; o++ {
+			length := int(auto.OutLen[o])
+			got = append(got, acMatch{i + 1 - length, i + 1, auto.OutTok[o]})
+		}
+	}
+	return got
+}
+
+func TestBuildACAutomaton(t *testing.T) {
+	data := []entry{
+		{Lower: "in", Match: "IN", Category: "R"},
+		{Lower: "inner", Match: "INNER", Category: "U"},
+		{Lower: "join", Match: "JOIN", Category: "R"},
+	}
+	auto := buildACAutomaton(data)
+
+	// "join" contains "in" as a non-prefix substring, reached only via a
+	// failure link; both must be reported (overlapping matches).
+	if got, want := scanWithAutomaton(auto, "join"), []acMatch{{0, 4, "JOIN"}, {2, 4, "IN"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("scan(%q) = %v, want %v", "join", got, want)
+	}
+
+	// "in" is a literal trie prefix of "inner"; both must be reported, and
+	// folding must be case-insensitive.
+	if got, want := scanWithAutomaton(auto, "INNER"), []acMatch{{0, 2, "IN"}, {0, 5, "INNER"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("scan(%q) = %v, want %v", "INNER", got, want)
+	}
+
+	// No keyword occurs in "xyz".
+	if got := scanWithAutomaton(auto, "xyz"); len(got) != 0 {
+		t.Fatalf("scan(%q) = %v, want no matches", "xyz", got)
+	}
+}
+
+// TestBuildACAutomatonNonLetterBytes guards against a panic when a keyword
+// contains a byte outside 'a'..'z', such as the underscore in the real SQL
+// keyword ANNOTATE_TYPE: the trie's transition table must index by the full
+// byte range, not assume every keyword byte is a lowercase letter.
+func TestBuildACAutomatonNonLetterBytes(t *testing.T) {
+	data := []entry{
+		{Lower: "annotate_type", Match: "ANNOTATE_TYPE", Category: "R"},
+		{Lower: "type", Match: "TYPE", Category: "U"},
+	}
+	auto := buildACAutomaton(data)
+
+	want := []acMatch{{0, 13, "ANNOTATE_TYPE"}, {9, 13, "TYPE"}}
+	if got := scanWithAutomaton(auto, "ANNOTATE_TYPE"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("scan(%q) = %v, want %v", "ANNOTATE_TYPE", got, want)
+	}
+}